@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default TTL for a cached inventory, in seconds.
+const defaultCacheMaxAge int = 300
+
+// Resolve the directory cached inventories are written to, honoring DO_CACHE_DIR
+// and falling back to $XDG_CACHE_HOME/ansibleinv-digitalocean (or
+// ~/.cache/ansibleinv-digitalocean if XDG_CACHE_HOME isn't set).
+func cacheDir() string {
+	if dir, ok := os.LookupEnv("DO_CACHE_DIR"); ok {
+		return dir
+	}
+
+	if xdg, ok := os.LookupEnv("XDG_CACHE_HOME"); ok {
+		return filepath.Join(xdg, "ansibleinv-digitalocean")
+	}
+
+	return filepath.Join(os.Getenv("HOME"), ".cache", "ansibleinv-digitalocean")
+}
+
+// How long a cached inventory is considered fresh, from DO_CACHE_MAX_AGE (seconds).
+func cacheMaxAge() time.Duration {
+	raw, ok := os.LookupEnv("DO_CACHE_MAX_AGE")
+	if !ok {
+		return time.Duration(defaultCacheMaxAge) * time.Second
+	}
+
+	secs, err := strconv.Atoi(raw)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: DO_CACHE_MAX_AGE must be an integer number of seconds, %s\n", err)
+		os.Exit(1)
+	}
+
+	return time.Duration(secs) * time.Second
+}
+
+// Everything besides the token that changes what the assembled inventory
+// looks like: the group prefix, which groupings are enabled, the host address
+// policy, and this account's include/exclude filters. Folding this into the
+// cache key means flipping one of these settings invalidates the cache
+// instead of silently serving the old shape until DO_CACHE_MAX_AGE expires.
+func cacheFingerprint(acc accountConfig, prefix string) string {
+	return strings.Join([]string{
+		prefix,
+		os.Getenv("DO_GROUPS"),
+		os.Getenv("DO_HOST_ADDRESS"),
+		strings.Join(acc.IncludeRegions, ","),
+		strings.Join(acc.ExcludeRegions, ","),
+		strings.Join(acc.IncludeTags, ","),
+		strings.Join(acc.ExcludeTags, ","),
+	}, "\x00")
+}
+
+// The cache is keyed by a hash of the token and the fingerprint above, so
+// that multiple accounts don't collide and changing a setting that affects
+// the assembled inventory misses the cache instead of returning stale data.
+// The token itself is never written to disk.
+func cacheKey(token, fingerprint string) string {
+	sum := sha256.Sum256([]byte(token + "\x00" + fingerprint))
+	return hex.EncodeToString(sum[:])
+}
+
+func cachePath(token, fingerprint string) string {
+	return filepath.Join(cacheDir(), cacheKey(token, fingerprint)+".json")
+}
+
+// Load the cached inventory for token/fingerprint, if present and younger
+// than maxAge. The second return value reports whether the cache was usable.
+func loadCache(token, fingerprint string, maxAge time.Duration) (ansibleInventory, bool) {
+	path := cachePath(token, fingerprint)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return ansibleInventory{}, false
+	}
+
+	if time.Since(info.ModTime()) > maxAge {
+		return ansibleInventory{}, false
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ansibleInventory{}, false
+	}
+
+	cached := ansibleInventory{}
+	if err := json.Unmarshal(b, &cached); err != nil {
+		return ansibleInventory{}, false
+	}
+
+	return cached, true
+}
+
+// Persist the assembled inventory to disk so the next invocation can skip both
+// the API calls and the grouping work.
+func saveCache(token, fingerprint string, inv ansibleInventory) error {
+	dir := cacheDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create cache dir %s: %s", dir, err)
+	}
+
+	b, err := json.Marshal(inv)
+	if err != nil {
+		return fmt.Errorf("failed to marshal inventory for caching: %s", err)
+	}
+
+	if err := ioutil.WriteFile(cachePath(token, fingerprint), b, 0600); err != nil {
+		return fmt.Errorf("failed to write cache file: %s", err)
+	}
+
+	return nil
+}
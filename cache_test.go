@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCacheFingerprintChangesWithSettings(t *testing.T) {
+	acc := accountConfig{IncludeRegions: []string{"nyc.*"}}
+
+	base := cacheFingerprint(acc, "do_")
+
+	os.Setenv("DO_GROUPS", "region,tag")
+	defer os.Unsetenv("DO_GROUPS")
+
+	if changed := cacheFingerprint(acc, "do_"); changed == base {
+		t.Fatalf("expected fingerprint to change when DO_GROUPS changes, got the same value %q", changed)
+	}
+}
+
+func TestCacheFingerprintStableForSameSettings(t *testing.T) {
+	acc := accountConfig{IncludeTags: []string{"web"}}
+
+	first := cacheFingerprint(acc, "do_")
+	second := cacheFingerprint(acc, "do_")
+
+	if first != second {
+		t.Fatalf("expected identical fingerprints for identical settings, got %q and %q", first, second)
+	}
+}
+
+func TestCacheKeyDiffersByToken(t *testing.T) {
+	fp := cacheFingerprint(accountConfig{}, "do_")
+
+	if cacheKey("token-a", fp) == cacheKey("token-b", fp) {
+		t.Fatalf("expected different tokens to produce different cache keys")
+	}
+}
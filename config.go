@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+// One named account from the config file.
+type accountConfig struct {
+	Name           string   `yaml:"-"`
+	Token          string   `yaml:"token"`
+	GroupPrefix    string   `yaml:"group_prefix"`
+	IncludeRegions []string `yaml:"include_regions"`
+	ExcludeRegions []string `yaml:"exclude_regions"`
+	IncludeTags    []string `yaml:"include_tags"`
+	ExcludeTags    []string `yaml:"exclude_tags"`
+}
+
+// The top-level shape of digitalocean.yml.
+type fileConfig struct {
+	Accounts map[string]accountConfig `yaml:"accounts"`
+	Merge    bool                     `yaml:"merge"`
+}
+
+// Where to look for the config file: $DO_CONFIG, or ./digitalocean.yml.
+func configPath() string {
+	if p, ok := os.LookupEnv("DO_CONFIG"); ok {
+		return p
+	}
+	return "digitalocean.yml"
+}
+
+// Load and parse the config file, if one is present. A missing file is not an
+// error: it just means the caller should fall back to single-account,
+// env-var-only behavior.
+func loadConfig() (*fileConfig, error) {
+	path := configPath()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+
+	cfg := fileConfig{}
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+
+	for name, acc := range cfg.Accounts {
+		acc.Name = name
+		cfg.Accounts[name] = acc
+	}
+
+	return &cfg, nil
+}
+
+// Compiled include/exclude regex filters for a single account.
+type accountFilters struct {
+	includeRegions []*regexp.Regexp
+	excludeRegions []*regexp.Regexp
+	includeTags    []*regexp.Regexp
+	excludeTags    []*regexp.Regexp
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", p, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+func compileFilters(acc accountConfig) (accountFilters, error) {
+	var f accountFilters
+	var err error
+
+	if f.includeRegions, err = compilePatterns(acc.IncludeRegions); err != nil {
+		return f, err
+	}
+	if f.excludeRegions, err = compilePatterns(acc.ExcludeRegions); err != nil {
+		return f, err
+	}
+	if f.includeTags, err = compilePatterns(acc.IncludeTags); err != nil {
+		return f, err
+	}
+	if f.excludeTags, err = compilePatterns(acc.ExcludeTags); err != nil {
+		return f, err
+	}
+
+	return f, nil
+}
+
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAnyTag(patterns []*regexp.Regexp, tags []string) bool {
+	for _, t := range tags {
+		if matchesAny(patterns, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether d passes this account's include/exclude filters.
+func (f accountFilters) allows(region string, tags []string) bool {
+	if len(f.includeRegions) > 0 && !matchesAny(f.includeRegions, region) {
+		return false
+	}
+	if matchesAny(f.excludeRegions, region) {
+		return false
+	}
+	if len(f.includeTags) > 0 && !matchesAnyTag(f.includeTags, tags) {
+		return false
+	}
+	if matchesAnyTag(f.excludeTags, tags) {
+		return false
+	}
+	return true
+}
+
+// Two different accounts can easily have a same-named droplet (e.g. "web-1"
+// in both a staging and a prod account). Since group names are already
+// namespaced per account via their group prefix, but the droplet name itself
+// isn't, we namespace the host identifier by account here so merging never
+// clobbers one account's hostvars with another's.
+func namespacedHost(accountName, host string) string {
+	return accountName + "__" + host
+}
+
+// Merge src's groups and hostvars into dst, namespacing every host by
+// accountName so same-named droplets in different accounts can't collide.
+// Group names are assumed to already be namespaced via each account's own
+// group prefix, but accounts can share a prefix on purpose (e.g. a common
+// group_prefix for unified region/tag groups across accounts), so a group of
+// the same name from two accounts is merged rather than overwritten: hosts
+// and children are appended, and vars are merged key-by-key with the later
+// account's value winning on a conflict.
+func mergeInventory(dst *ansibleInventory, accountName string, src ansibleInventory) {
+	for name, group := range src.Groups {
+		renamedHosts := make([]string, len(group.Hosts))
+		for i, host := range group.Hosts {
+			renamedHosts[i] = namespacedHost(accountName, host)
+		}
+
+		existing, ok := dst.Groups[name]
+		if !ok {
+			dst.Groups[name] = &ansibleGroup{
+				Hosts:    renamedHosts,
+				Vars:     group.Vars,
+				Children: group.Children,
+			}
+			continue
+		}
+
+		existing.Hosts = append(existing.Hosts, renamedHosts...)
+		existing.Children = append(existing.Children, group.Children...)
+		for k, v := range group.Vars {
+			if existing.Vars == nil {
+				existing.Vars = make(map[string]interface{})
+			}
+			existing.Vars[k] = v
+		}
+	}
+	for host, vars := range src.Meta.Hostvars {
+		dst.Meta.Hostvars[namespacedHost(accountName, host)] = vars
+	}
+}
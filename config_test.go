@@ -0,0 +1,99 @@
+package main
+
+import "testing"
+
+func TestCompileFiltersRejectsInvalidRegex(t *testing.T) {
+	acc := accountConfig{IncludeRegions: []string{"nyc["}}
+
+	if _, err := compileFilters(acc); err == nil {
+		t.Fatalf("expected an error for an invalid regex pattern")
+	}
+}
+
+func TestAccountFiltersAllows(t *testing.T) {
+	acc := accountConfig{
+		IncludeRegions: []string{"^nyc"},
+		ExcludeTags:    []string{"decommissioned"},
+	}
+	filters, err := compileFilters(acc)
+	if err != nil {
+		t.Fatalf("compileFilters() error = %s", err)
+	}
+
+	cases := []struct {
+		name   string
+		region string
+		tags   []string
+		want   bool
+	}{
+		{"matches include region, no excluded tag", "nyc3", []string{"web"}, true},
+		{"fails include region", "ams3", []string{"web"}, false},
+		{"matches include region but carries excluded tag", "nyc3", []string{"decommissioned"}, false},
+	}
+
+	for _, tc := range cases {
+		if got := filters.allows(tc.region, tc.tags); got != tc.want {
+			t.Errorf("%s: allows(%q, %v) = %v, want %v", tc.name, tc.region, tc.tags, got, tc.want)
+		}
+	}
+}
+
+func TestAccountFiltersAllowsWithNoFiltersConfigured(t *testing.T) {
+	filters, err := compileFilters(accountConfig{})
+	if err != nil {
+		t.Fatalf("compileFilters() error = %s", err)
+	}
+
+	if !filters.allows("nyc3", []string{"anything"}) {
+		t.Fatalf("expected no filters configured to allow everything")
+	}
+}
+
+func TestMergeInventoryNamespacesHostsByAccount(t *testing.T) {
+	dst := ansibleInventory{Groups: make(map[string]*ansibleGroup)}
+	dst.Meta.Hostvars = make(map[string]interface{})
+
+	src := ansibleInventory{Groups: map[string]*ansibleGroup{
+		"do_web": {Hosts: []string{"web-1"}},
+	}}
+	src.Meta.Hostvars = map[string]interface{}{"web-1": map[string]interface{}{"do_ipv4_public": "203.0.113.1"}}
+
+	mergeInventory(&dst, "staging", src)
+
+	group, ok := dst.Groups["do_web"]
+	if !ok || len(group.Hosts) != 1 || group.Hosts[0] != "staging__web-1" {
+		t.Fatalf("expected host namespaced as staging__web-1, got %v", dst.Groups)
+	}
+	if _, ok := dst.Meta.Hostvars["staging__web-1"]; !ok {
+		t.Fatalf("expected hostvars namespaced as staging__web-1, got %v", dst.Meta.Hostvars)
+	}
+}
+
+func TestMergeInventoryMergesGroupsThatShareAName(t *testing.T) {
+	dst := ansibleInventory{Groups: make(map[string]*ansibleGroup)}
+	dst.Meta.Hostvars = make(map[string]interface{})
+
+	// Two accounts with the same explicit group_prefix both produce a
+	// "shared_nyc3" group; neither account's hosts should be lost.
+	first := ansibleInventory{Groups: map[string]*ansibleGroup{
+		"shared_nyc3": {Hosts: []string{"web-1"}, Vars: map[string]interface{}{"a": 1}},
+	}}
+	second := ansibleInventory{Groups: map[string]*ansibleGroup{
+		"shared_nyc3": {Hosts: []string{"web-1"}, Vars: map[string]interface{}{"b": 2}},
+	}}
+
+	mergeInventory(&dst, "acct1", first)
+	mergeInventory(&dst, "acct2", second)
+
+	group, ok := dst.Groups["shared_nyc3"]
+	if !ok {
+		t.Fatalf("expected shared_nyc3 group to exist")
+	}
+	wantHosts := []string{"acct1__web-1", "acct2__web-1"}
+	if len(group.Hosts) != len(wantHosts) || group.Hosts[0] != wantHosts[0] || group.Hosts[1] != wantHosts[1] {
+		t.Fatalf("expected hosts from both accounts, got %v", group.Hosts)
+	}
+	if group.Vars["a"] != 1 || group.Vars["b"] != 2 {
+		t.Fatalf("expected vars from both accounts, got %v", group.Vars)
+	}
+}
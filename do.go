@@ -1,68 +1,31 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"os"
+
+	"github.com/digitalocean/godo"
+	"golang.org/x/oauth2"
 )
 
-const ansibleGroupPrefix string = "do_"
-const doApi string = "https://api.digitalocean.com/v2"
+// How many droplets to request per page. DigitalOcean's hard cap is 200.
+const dropletsPerPage int = 200
+
+// Retry/backoff for requests that fail with 429 or 500-level responses is
+// owned entirely by godo's go-retryablehttp-backed RetryConfig (see
+// newDoClient) so we don't end up with two independent backoff loops
+// multiplying wait times during an outage.
+const retryMax int = 5
 
-var ai = ansibleInventory{}
 var environVars = map[string]string{
 	"DO_TOKEN": "The Digital Ocean token API access key",
 }
 
 type doClient struct {
-	api   string
-	token string
-}
-
-// The response from querying /droplets
-type dropletsResponse struct {
-	Droplets []droplet `json:"droplets"`
-	Links    struct {
-		First string `json:"first,omitempty"`
-		Prev  string `json:"prev,omitempty"`
-		Next  string `json:"next,omitempty"`
-		Last  string `json:"last,omitempty"`
-	} `json:"links"`
-	Meta struct {
-		Total int `json:"total,omitempty"`
-	} `json:"meta"`
-}
-
-// The structure of a droplet with only the relelvant fields for inventory generation
-type droplet struct {
-	Id       int      `json:"id"`
-	Name     string   `json:"name"`
-	Features []string `json:"features"`
-	Region   struct {
-		Slug string `json:"slug"`
-		Name string `json:"name"`
-	} `json:"region"`
-	Image struct {
-		id   int    `json:"id"`
-		name string `json:"name"`
-	} `json:"image"`
-	Networks struct {
-		V4 []struct {
-			IpAddress string `json:"ip_address"`
-			Netmask   string `json:"netmask"`
-			Gateway   string `json:"gateway"`
-			Type      string `json:"type"`
-		} `json:"v4"`
-		V6 []struct {
-			IpAddress string `json:"ip_address"`
-			Netmask   int    `json:"netmask"`
-			Gateway   string `json:"gateway"`
-			Type      string `json:"type"`
-		}
-	} `json:"networks"`
-	Tags []string `json:"tags"`
+	gc *godo.Client
 }
 
 // Top level Ansible Inventory
@@ -84,6 +47,26 @@ type ansibleGroup struct {
 	Children []string               `json:"children,omitempty"`
 }
 
+// The parsed command-line flags, following Ansible's dynamic-inventory script contract:
+// https://docs.ansible.com/ansible/latest/plugins/inventory/script.html
+type cliFlags struct {
+	list         bool
+	host         string
+	pretty       bool
+	refreshCache bool
+}
+
+// Parse argv into the flags Ansible's dynamic-inventory contract expects.
+func parseFlags() cliFlags {
+	list := flag.Bool("list", false, "print the full inventory as JSON")
+	host := flag.String("host", "", "print hostvars for a single host as JSON")
+	pretty := flag.Bool("pretty", false, "indent the JSON output")
+	refreshCache := flag.Bool("refresh-cache", false, "bypass the on-disk cache and re-fetch from the API")
+	flag.Parse()
+
+	return cliFlags{list: *list, host: *host, pretty: *pretty, refreshCache: *refreshCache}
+}
+
 // Assert that we have the necessary environment variables set.
 func assertEnvironSet() {
 	// Assume first we have all flags, otherwise flip it later. This way we can print out all missing variables instead of
@@ -93,7 +76,7 @@ func assertEnvironSet() {
 		_, err := os.LookupEnv(k)
 		if err == false {
 			hasVars = false
-			fmt.Printf("error: %s environment variable is missing, %s\n", k, v)
+			fmt.Fprintf(os.Stderr, "error: %s environment variable is missing, %s\n", k, v)
 		}
 	}
 
@@ -102,124 +85,301 @@ func assertEnvironSet() {
 	}
 }
 
-// Create a new doClient to make all the API calls for us.
-func createDoClient() doClient {
-	return doClient{doApi, os.Getenv("DO_TOKEN")}
-}
+// Build the inventory for a single account: serve it from cache if possible,
+// otherwise hit the API and cache the result under that account's token.
+func runAccount(acc accountConfig, prefix string, flags cliFlags) (ansibleInventory, error) {
+	fingerprint := cacheFingerprint(acc, prefix)
+
+	if !flags.refreshCache {
+		if cached, ok := loadCache(acc.Token, fingerprint, cacheMaxAge()); ok {
+			return cached, nil
+		}
+	}
 
-// A generic wrapper to make a GET call. Return []byte and let other functions handle what to do with it.
-func (c doClient) doGet(endpoint string) []byte {
-	// Make a new request because we need to add custom headers
-	req, err := http.NewRequest("GET", c.api+endpoint, nil)
+	filters, err := compileFilters(acc)
 	if err != nil {
-		fmt.Printf("error: failed to create request to %s, %s\n", endpoint, err)
-		os.Exit(1)
+		return ansibleInventory{}, fmt.Errorf("account %s: %s", acc.Name, err)
 	}
 
-	// Add the necessary header
-	req.Header.Add("Authorization", "Bearer "+c.token)
+	client, err := newDoClient(acc.Token)
+	if err != nil {
+		return ansibleInventory{}, fmt.Errorf("account %s: %s", acc.Name, err)
+	}
 
-	// Create a new HTTP client and make the call
-	hc := http.Client{}
-	resp, err := hc.Do(req)
+	inv, err := client.createInventory(prefix, filters)
 	if err != nil {
-		fmt.Printf("error: failed to get %s, %s\n", req.URL, err)
-		os.Exit(1)
+		return inv, fmt.Errorf("account %s: %s", acc.Name, err)
+	}
+
+	if err := saveCache(acc.Token, fingerprint, inv); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: account %s: failed to write inventory cache, %s\n", acc.Name, err)
 	}
 
-	// Get the byte array and return it
-	b, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
+	return inv, nil
+}
+
+// Build a godo client for token with retries and backoff for 429/500-level
+// responses enabled via RetryConfig. This is the only layer that retries a
+// request; callers should treat an error coming back from the client as
+// final.
+func newDoClient(token string) (doClient, error) {
+	oauthClient := oauth2.NewClient(context.Background(), oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
+
+	gc, err := godo.New(oauthClient, godo.WithRetryAndBackoffs(godo.RetryConfig{RetryMax: retryMax}))
 	if err != nil {
-		fmt.Printf("error: failed to read body, %s\n", err)
+		return doClient{}, fmt.Errorf("failed to create DigitalOcean client: %s", err)
 	}
 
-	return b
+	return doClient{gc}, nil
 }
 
-// Generate the inventory structures
-func (c doClient) createInventory() {
-	// Get my droplet output first
-	b := c.doGet("/droplets")
+// List every droplet on the account, transparently following pagination.
+// Transient failures are retried by the underlying client (see newDoClient).
+func (c doClient) listAllDroplets() ([]godo.Droplet, error) {
+	ctx := context.TODO()
+	opt := &godo.ListOptions{
+		Page:    1,
+		PerPage: dropletsPerPage,
+	}
+
+	var all []godo.Droplet
+	for {
+		droplets, resp, err := c.gc.Droplets.List(ctx, opt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list droplets: %s", err)
+		}
 
-	// Convert it into the struct
-	dResponse := dropletsResponse{}
-	err := json.Unmarshal(b, &dResponse)
+		all = append(all, droplets...)
+
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			break
+		}
+
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine next page: %s", err)
+		}
+		opt.Page = page + 1
+	}
+
+	return all, nil
+}
+
+// A droplet that hasn't finished provisioning yet (status "new") can come
+// back from the API with a nil Region, same as the nil Networks case handled
+// in hostaddr.go. Guard it here so one not-yet-provisioned droplet doesn't
+// panic the whole run.
+func regionSlug(d godo.Droplet) string {
+	if d.Region == nil {
+		return ""
+	}
+	return d.Region.Slug
+}
+
+// Generate the inventory structures for this account, applying filters to
+// decide which droplets are included.
+func (c doClient) createInventory(prefix string, filters accountFilters) (ansibleInventory, error) {
+	inv := ansibleInventory{}
+	inv.Groups = make(map[string]*ansibleGroup)
+	inv.Meta.Hostvars = make(map[string]interface{})
+
+	droplets, err := c.listAllDroplets()
 	if err != nil {
-		fmt.Println("error: unmarshal failed, %s\n", err)
+		return inv, fmt.Errorf("failed to list droplets: %s", err)
 	}
 
+	ctx := context.TODO()
+	floatingIPs, err := c.fetchFloatingIPs(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to fetch floating IPs, %s\n", err)
+		floatingIPs = make(map[int]string)
+	}
+
+	enabled := enabledGroupings()
+	policy := hostAddressPolicy()
+	seenTags := make(map[string]bool)
+
 	// Iterate every droplet
-	for _, d := range dResponse.Droplets {
-		// Group based on region
-		if _, ok := ai.Groups[ansibleGroupPrefix+d.Region.Slug]; !ok {
-			// Region does not exist, init the ansibleGroup with the first host
-			ai.Groups[ansibleGroupPrefix+d.Region.Slug] = &ansibleGroup{
-				Hosts: []string{d.Name},
-			}
-		} else {
-			// Region exists, just append the host
-			ai.Groups[ansibleGroupPrefix+d.Region.Slug].Hosts = append(ai.Groups[ansibleGroupPrefix+d.Region.Slug].Hosts, d.Name)
+	for _, d := range droplets {
+		if !filters.allows(regionSlug(d), d.Tags) {
+			continue
 		}
 
-		// Groups based on tags
-		for _, t := range d.Tags {
-			if _, ok := ai.Groups[ansibleGroupPrefix+t]; !ok {
-				ai.Groups[ansibleGroupPrefix+t] = &ansibleGroup{
-					Hosts: []string{d.Name},
-				}
-			} else {
-				ai.Groups[ansibleGroupPrefix+t].Hosts = append(ai.Groups[ansibleGroupPrefix+t].Hosts, d.Name)
-			}
-		}
+		groupDroplet(&inv, d, prefix, enabled)
 
 		// Setup hostvars for each droplet now
-		if _, ok := ai.Meta.Hostvars[d.Name]; !ok {
-			ai.Meta.Hostvars[d.Name] = make(map[string]interface{})
+		if _, ok := inv.Meta.Hostvars[d.Name]; !ok {
+			inv.Meta.Hostvars[d.Name] = make(map[string]interface{})
 		}
-		// Set up ansible_ssh per host
-		for _, n := range d.Networks.V4 {
-			// We want only the Public IPv4
-			if n.Type == "public" {
-				ai.Meta.Hostvars[d.Name].(map[string]interface{})["ansible_host"] = n.IpAddress
-				break
-			}
+		hostvars := inv.Meta.Hostvars[d.Name].(map[string]interface{})
+		setHostAddress(d, floatingIPs[d.ID], policy, hostvars)
+
+		applyTagMetadata(&inv, d, prefix)
+		for _, t := range d.Tags {
+			seenTags[t] = true
 		}
 	}
+
+	tags := make([]string, 0, len(seenTags))
+	for t := range seenTags {
+		tags = append(tags, t)
+	}
+	c.linkSharedTagGroups(ctx, &inv, prefix, tags)
+
+	return inv, nil
+}
+
+// Marshal v as JSON, honoring --pretty, and print it to stdout.
+func printJSON(v interface{}, pretty bool) {
+	var jsonOut []byte
+	var err error
+	if pretty {
+		jsonOut, err = json.MarshalIndent(v, "", "  ")
+	} else {
+		jsonOut, err = json.Marshal(v)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: marshal failed, %s", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s", jsonOut)
 }
 
 // Print the inventory out to stdout for ansible to suck up
-func (a ansibleInventory) printInventory() {
+func (a ansibleInventory) printInventory(pretty bool) {
 	// A map to hold the final output
 	printMap := make(map[string]interface{})
 
 	// Iterate over groups first
-	for k, v := range ai.Groups {
+	for k, v := range a.Groups {
 		printMap[k] = v
 	}
 
 	// Init the nested maps under _meta
-	printMap["_meta"] = ai.Meta
+	printMap["_meta"] = a.Meta
+
+	printJSON(printMap, pretty)
+}
+
+// Print just the hostvars for a single host, for Ansible's --host fast path.
+func (a ansibleInventory) printHostvars(host string, pretty bool) {
+	hostvars, ok := a.Meta.Hostvars[host]
+	if !ok {
+		hostvars = make(map[string]interface{})
+	}
+
+	printJSON(hostvars, pretty)
+}
+
+// Run every account in cfg concurrently and merge their inventories together,
+// each namespaced under its own group prefix.
+func runMergedAccounts(cfg *fileConfig, flags cliFlags) ansibleInventory {
+	type accountResult struct {
+		name string
+		inv  ansibleInventory
+		err  error
+	}
+
+	results := make(chan accountResult, len(cfg.Accounts))
+	for name, acc := range cfg.Accounts {
+		go func(name string, acc accountConfig) {
+			prefix := acc.GroupPrefix
+			if prefix == "" {
+				prefix = fmt.Sprintf("acct_%s_%s", name, groupPrefix())
+			}
+			inv, err := runAccount(acc, prefix, flags)
+			results <- accountResult{name, inv, err}
+		}(name, acc)
+	}
+
+	merged := ansibleInventory{Groups: make(map[string]*ansibleGroup)}
+	merged.Meta.Hostvars = make(map[string]interface{})
+	for range cfg.Accounts {
+		r := <-results
+		if r.err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", r.err)
+			continue
+		}
+		mergeInventory(&merged, r.name, r.inv)
+	}
 
-	// Print it out in JSON
-	jsonOut, err := json.Marshal(printMap)
+	return merged
+}
+
+func main() {
+	flags := parseFlags()
+
+	cfg, err := loadConfig()
 	if err != nil {
-		fmt.Printf("error: marshal failed, %s", err)
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
 		os.Exit(1)
 	}
-	fmt.Printf("%s", jsonOut)
+
+	var inv ansibleInventory
+
+	switch {
+	case cfg == nil:
+		// No config file: fall back to the single DO_TOKEN-driven account.
+		assertEnvironSet()
+		acc := accountConfig{Name: "default", Token: os.Getenv("DO_TOKEN")}
+		inv, err = runAccount(acc, groupPrefix(), flags)
+
+	case cfg.Merge:
+		inv = runMergedAccounts(cfg, flags)
+
+	default:
+		name := os.Getenv("DO_ACCOUNT")
+		acc, ok := cfg.Accounts[name]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "error: set DO_ACCOUNT to one of the accounts in %s, or set merge: true to run them all\n", configPath())
+			os.Exit(1)
+		}
+		prefix := acc.GroupPrefix
+		if prefix == "" {
+			prefix = groupPrefix()
+		}
+		inv, err = runAccount(acc, prefix, flags)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+
+	printForFlags(inv, flags)
 }
 
-func main() {
-	// Check our environment variables
-	assertEnvironSet()
+// The three outcomes Ansible's dynamic-inventory contract defines: print a
+// single host's vars, print the whole inventory, or (neither flag given) fail.
+const (
+	outputHostvars string = "hostvars"
+	outputList     string = "list"
+	outputInvalid  string = "invalid"
+)
 
-	// Initialize the maps inside of our global ansibleInventory
-	ai.Groups = make(map[string]*ansibleGroup)
-	ai.Meta.Hostvars = make(map[string]interface{})
+// Which of the three outcomes flags selects. Split out from printForFlags so
+// the dispatch logic can be tested without an actual inventory or API calls.
+func outputModeFor(flags cliFlags) string {
+	switch {
+	case flags.host != "":
+		return outputHostvars
+	case flags.list:
+		return outputList
+	default:
+		return outputInvalid
+	}
+}
 
-	// Do work
-	doClient := createDoClient()
-	doClient.createInventory()
-	ai.printInventory()
+// Print inv per flags's --list/--host selection, or fail per Ansible's
+// dynamic-inventory contract if neither was given.
+func printForFlags(inv ansibleInventory, flags cliFlags) {
+	switch outputModeFor(flags) {
+	case outputHostvars:
+		inv.printHostvars(flags.host, flags.pretty)
+	case outputList:
+		inv.printInventory(flags.pretty)
+	default:
+		fmt.Fprintln(os.Stderr, "error: expected --list or --host <name>")
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestOutputModeFor(t *testing.T) {
+	cases := []struct {
+		name  string
+		flags cliFlags
+		want  string
+	}{
+		{"host only", cliFlags{host: "web-1"}, outputHostvars},
+		{"list only", cliFlags{list: true}, outputList},
+		{"host takes priority over list", cliFlags{host: "web-1", list: true}, outputHostvars},
+		{"neither given", cliFlags{}, outputInvalid},
+	}
+
+	for _, tc := range cases {
+		if got := outputModeFor(tc.flags); got != tc.want {
+			t.Errorf("%s: outputModeFor(%+v) = %q, want %q", tc.name, tc.flags, got, tc.want)
+		}
+	}
+}
+
+func TestPrintHostvarsUnknownHostPrintsEmptyObject(t *testing.T) {
+	inv := ansibleInventory{}
+	inv.Meta.Hostvars = map[string]interface{}{
+		"web-1": map[string]interface{}{"do_ipv4_public": "203.0.113.1"},
+	}
+
+	out := captureStdout(t, func() {
+		inv.printHostvars("no-such-host", false)
+	})
+
+	if out != "{}" {
+		t.Fatalf("printHostvars(unknown host) = %q, want %q", out, "{}")
+	}
+}
+
+func TestPrintHostvarsKnownHost(t *testing.T) {
+	inv := ansibleInventory{}
+	inv.Meta.Hostvars = map[string]interface{}{
+		"web-1": map[string]interface{}{"do_ipv4_public": "203.0.113.1"},
+	}
+
+	out := captureStdout(t, func() {
+		inv.printHostvars("web-1", false)
+	})
+
+	if out != `{"do_ipv4_public":"203.0.113.1"}` {
+		t.Fatalf("printHostvars(web-1) = %q", out)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/digitalocean/godo"
+)
+
+// The full set of groupings we know how to emit. DO_GROUPS can restrict this
+// list so inventories don't explode on large accounts.
+const allGroupings string = "region,tag,image,size,status,vpc,features,tagged"
+
+// The prefix every group name is given, configurable via DO_GROUP_PREFIX.
+func groupPrefix() string {
+	if p, ok := os.LookupEnv("DO_GROUP_PREFIX"); ok {
+		return p
+	}
+	return "do_"
+}
+
+// Which groupings are turned on, from DO_GROUPS (default: all of them).
+func enabledGroupings() map[string]bool {
+	raw := allGroupings
+	if v, ok := os.LookupEnv("DO_GROUPS"); ok {
+		raw = v
+	}
+
+	enabled := make(map[string]bool)
+	for _, g := range strings.Split(raw, ",") {
+		g = strings.TrimSpace(g)
+		if g != "" {
+			enabled[g] = true
+		}
+	}
+	return enabled
+}
+
+// Lowercase and underscore a free-form string (e.g. an image distribution
+// name) so it's safe to use as part of a group name.
+func slugify(s string) string {
+	return strings.ToLower(strings.ReplaceAll(s, " ", "_"))
+}
+
+// Add host to the named group, creating the group if this is its first host.
+func addToGroup(inv *ansibleInventory, name, host string) {
+	if _, ok := inv.Groups[name]; !ok {
+		inv.Groups[name] = &ansibleGroup{
+			Hosts: []string{host},
+		}
+	} else {
+		inv.Groups[name].Hosts = append(inv.Groups[name].Hosts, host)
+	}
+}
+
+// Place d into every group its attributes qualify it for, per the enabled
+// groupings and prefix.
+func groupDroplet(inv *ansibleInventory, d godo.Droplet, prefix string, enabled map[string]bool) {
+	if enabled["region"] && regionSlug(d) != "" {
+		addToGroup(inv, prefix+regionSlug(d), d.Name)
+	}
+
+	if enabled["tag"] {
+		for _, t := range d.Tags {
+			// ansible_var__/ansible_group__ tags are handled separately by
+			// applyTagMetadata, not as raw tag groups.
+			if isMetadataTag(t) {
+				continue
+			}
+			addToGroup(inv, prefix+t, d.Name)
+		}
+	}
+
+	if enabled["image"] && d.Image != nil {
+		if d.Image.Slug != "" {
+			addToGroup(inv, prefix+"image_"+d.Image.Slug, d.Name)
+		}
+		if d.Image.Distribution != "" {
+			addToGroup(inv, prefix+"distro_"+slugify(d.Image.Distribution), d.Name)
+		}
+	}
+
+	if enabled["size"] && d.SizeSlug != "" {
+		addToGroup(inv, prefix+"size_"+d.SizeSlug, d.Name)
+	}
+
+	if enabled["status"] && d.Status != "" {
+		addToGroup(inv, prefix+"status_"+d.Status, d.Name)
+	}
+
+	if enabled["vpc"] && d.VPCUUID != "" {
+		addToGroup(inv, prefix+"vpc_"+d.VPCUUID, d.Name)
+	}
+
+	if enabled["features"] {
+		for _, f := range d.Features {
+			addToGroup(inv, prefix+"feature_"+f, d.Name)
+		}
+	}
+
+	if enabled["tagged"] {
+		if len(d.Tags) > 0 {
+			addToGroup(inv, prefix+"tagged", d.Name)
+		} else {
+			addToGroup(inv, prefix+"untagged", d.Name)
+		}
+	}
+}
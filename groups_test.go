@@ -0,0 +1,186 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/digitalocean/godo"
+)
+
+func TestGroupDropletSkipsRegionGroupingForNilRegion(t *testing.T) {
+	inv := newInventoryForTest()
+	d := godo.Droplet{Name: "new-droplet", Region: nil}
+
+	groupDroplet(inv, d, "do_", map[string]bool{"region": true})
+
+	for name := range inv.Groups {
+		t.Fatalf("expected no region group for a droplet with nil Region, got %q", name)
+	}
+}
+
+func TestGroupDropletGroupsByRegion(t *testing.T) {
+	inv := newInventoryForTest()
+	d := godo.Droplet{Name: "web-1", Region: &godo.Region{Slug: "nyc3"}}
+
+	groupDroplet(inv, d, "do_", map[string]bool{"region": true})
+
+	group, ok := inv.Groups["do_nyc3"]
+	if !ok || len(group.Hosts) != 1 || group.Hosts[0] != "web-1" {
+		t.Fatalf("expected web-1 in do_nyc3, got %v", inv.Groups)
+	}
+}
+
+func hasHost(inv *ansibleInventory, group, host string) bool {
+	g, ok := inv.Groups[group]
+	if !ok {
+		return false
+	}
+	for _, h := range g.Hosts {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGroupDropletByImage(t *testing.T) {
+	inv := newInventoryForTest()
+	d := godo.Droplet{Name: "web-1", Image: &godo.Image{Slug: "ubuntu-22-04-x64", Distribution: "Ubuntu"}}
+
+	groupDroplet(inv, d, "do_", map[string]bool{"image": true})
+
+	if !hasHost(inv, "do_image_ubuntu-22-04-x64", "web-1") {
+		t.Errorf("expected web-1 in do_image_ubuntu-22-04-x64, got %v", inv.Groups)
+	}
+	if !hasHost(inv, "do_distro_ubuntu", "web-1") {
+		t.Errorf("expected web-1 in do_distro_ubuntu, got %v", inv.Groups)
+	}
+}
+
+func TestGroupDropletByImageSkipsUnsetFields(t *testing.T) {
+	inv := newInventoryForTest()
+	d := godo.Droplet{Name: "web-1", Image: nil}
+
+	groupDroplet(inv, d, "do_", map[string]bool{"image": true})
+
+	if len(inv.Groups) != 0 {
+		t.Fatalf("expected no image groups for a droplet with nil Image, got %v", inv.Groups)
+	}
+}
+
+func TestGroupDropletBySize(t *testing.T) {
+	inv := newInventoryForTest()
+	d := godo.Droplet{Name: "web-1", SizeSlug: "s-1vcpu-1gb"}
+
+	groupDroplet(inv, d, "do_", map[string]bool{"size": true})
+
+	if !hasHost(inv, "do_size_s-1vcpu-1gb", "web-1") {
+		t.Errorf("expected web-1 in do_size_s-1vcpu-1gb, got %v", inv.Groups)
+	}
+}
+
+func TestGroupDropletByStatus(t *testing.T) {
+	inv := newInventoryForTest()
+	d := godo.Droplet{Name: "web-1", Status: "active"}
+
+	groupDroplet(inv, d, "do_", map[string]bool{"status": true})
+
+	if !hasHost(inv, "do_status_active", "web-1") {
+		t.Errorf("expected web-1 in do_status_active, got %v", inv.Groups)
+	}
+}
+
+func TestGroupDropletByVPC(t *testing.T) {
+	inv := newInventoryForTest()
+	d := godo.Droplet{Name: "web-1", VPCUUID: "vpc-uuid-1"}
+
+	groupDroplet(inv, d, "do_", map[string]bool{"vpc": true})
+
+	if !hasHost(inv, "do_vpc_vpc-uuid-1", "web-1") {
+		t.Errorf("expected web-1 in do_vpc_vpc-uuid-1, got %v", inv.Groups)
+	}
+}
+
+func TestGroupDropletByFeatures(t *testing.T) {
+	inv := newInventoryForTest()
+	d := godo.Droplet{Name: "web-1", Features: []string{"private_networking", "monitoring"}}
+
+	groupDroplet(inv, d, "do_", map[string]bool{"features": true})
+
+	if !hasHost(inv, "do_feature_private_networking", "web-1") {
+		t.Errorf("expected web-1 in do_feature_private_networking, got %v", inv.Groups)
+	}
+	if !hasHost(inv, "do_feature_monitoring", "web-1") {
+		t.Errorf("expected web-1 in do_feature_monitoring, got %v", inv.Groups)
+	}
+}
+
+func TestGroupDropletTaggedVsUntagged(t *testing.T) {
+	inv := newInventoryForTest()
+	tagged := godo.Droplet{Name: "web-1", Tags: []string{"web"}}
+	untagged := godo.Droplet{Name: "web-2"}
+
+	groupDroplet(inv, tagged, "do_", map[string]bool{"tagged": true})
+	groupDroplet(inv, untagged, "do_", map[string]bool{"tagged": true})
+
+	if !hasHost(inv, "do_tagged", "web-1") {
+		t.Errorf("expected web-1 in do_tagged, got %v", inv.Groups)
+	}
+	if !hasHost(inv, "do_untagged", "web-2") {
+		t.Errorf("expected web-2 in do_untagged, got %v", inv.Groups)
+	}
+}
+
+func TestGroupDropletDisabledGroupingsAreSkipped(t *testing.T) {
+	inv := newInventoryForTest()
+	d := godo.Droplet{Name: "web-1", SizeSlug: "s-1vcpu-1gb", Status: "active"}
+
+	groupDroplet(inv, d, "do_", map[string]bool{})
+
+	if len(inv.Groups) != 0 {
+		t.Fatalf("expected no groups when nothing is enabled, got %v", inv.Groups)
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	if got := slugify("Ubuntu 22.04"); got != "ubuntu_22.04" {
+		t.Errorf("slugify(%q) = %q, want %q", "Ubuntu 22.04", got, "ubuntu_22.04")
+	}
+}
+
+func TestGroupPrefixDefaultAndOverride(t *testing.T) {
+	if got := groupPrefix(); got != "do_" {
+		t.Errorf("groupPrefix() = %q, want do_", got)
+	}
+
+	os.Setenv("DO_GROUP_PREFIX", "custom_")
+	defer os.Unsetenv("DO_GROUP_PREFIX")
+
+	if got := groupPrefix(); got != "custom_" {
+		t.Errorf("groupPrefix() = %q, want custom_", got)
+	}
+}
+
+func TestEnabledGroupingsDefaultsToAll(t *testing.T) {
+	enabled := enabledGroupings()
+
+	for _, g := range []string{"region", "tag", "image", "size", "status", "vpc", "features", "tagged"} {
+		if !enabled[g] {
+			t.Errorf("expected %q to be enabled by default, got %v", g, enabled)
+		}
+	}
+}
+
+func TestEnabledGroupingsHonorsDOGroups(t *testing.T) {
+	os.Setenv("DO_GROUPS", "region, size")
+	defer os.Unsetenv("DO_GROUPS")
+
+	enabled := enabledGroupings()
+
+	if !enabled["region"] || !enabled["size"] {
+		t.Fatalf("expected region and size enabled, got %v", enabled)
+	}
+	if enabled["tag"] || enabled["image"] {
+		t.Fatalf("expected tag and image disabled, got %v", enabled)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/digitalocean/godo"
+)
+
+// Recognized values for DO_HOST_ADDRESS.
+const (
+	hostAddrPublicV4   string = "public_v4"
+	hostAddrPrivateV4  string = "private_v4"
+	hostAddrPublicV6   string = "public_v6"
+	hostAddrFloatingIP string = "floating_ip"
+	hostAddrName       string = "name"
+)
+
+// How many floating IPs to request per page.
+const floatingIPsPerPage int = 200
+
+// Which address family DO_HOST_ADDRESS says ansible_host should be populated
+// with. Defaults to the historical "first public v4" behavior.
+func hostAddressPolicy() string {
+	if v, ok := os.LookupEnv("DO_HOST_ADDRESS"); ok {
+		return v
+	}
+	return hostAddrPublicV4
+}
+
+func publicV4(d godo.Droplet) string {
+	// Droplets that haven't finished provisioning yet (status "new") have a
+	// nil Networks.
+	if d.Networks == nil {
+		return ""
+	}
+	for _, n := range d.Networks.V4 {
+		if n.Type == "public" {
+			return n.IPAddress
+		}
+	}
+	return ""
+}
+
+func privateV4(d godo.Droplet) string {
+	if d.Networks == nil {
+		return ""
+	}
+	for _, n := range d.Networks.V4 {
+		if n.Type == "private" {
+			return n.IPAddress
+		}
+	}
+	return ""
+}
+
+func publicV6(d godo.Droplet) string {
+	if d.Networks == nil {
+		return ""
+	}
+	for _, n := range d.Networks.V6 {
+		if n.IPAddress != "" {
+			return n.IPAddress
+		}
+	}
+	return ""
+}
+
+// Fetch every floating IP on the account and return a map of droplet ID to
+// floating IP address, for droplets that have one assigned.
+func (c doClient) fetchFloatingIPs(ctx context.Context) (map[int]string, error) {
+	opt := &godo.ListOptions{Page: 1, PerPage: floatingIPsPerPage}
+	byDroplet := make(map[int]string)
+
+	for {
+		ips, resp, err := c.gc.FloatingIPs.List(ctx, opt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list floating IPs: %s", err)
+		}
+
+		for _, ip := range ips {
+			if ip.Droplet != nil {
+				byDroplet[ip.Droplet.ID] = ip.IP
+			}
+		}
+
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			break
+		}
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine next page: %s", err)
+		}
+		opt.Page = page + 1
+	}
+
+	return byDroplet, nil
+}
+
+// Populate the per-family address hostvars and pick ansible_host per policy,
+// falling back to whatever addresses the droplet actually has if the
+// preferred one is missing.
+func setHostAddress(d godo.Droplet, floatingIP string, policy string, hostvars map[string]interface{}) {
+	pubV4 := publicV4(d)
+	privV4 := privateV4(d)
+	pubV6 := publicV6(d)
+
+	if pubV4 != "" {
+		hostvars["do_ipv4_public"] = pubV4
+	}
+	if privV4 != "" {
+		hostvars["do_ipv4_private"] = privV4
+	}
+	if pubV6 != "" {
+		hostvars["do_ipv6"] = pubV6
+	}
+	if floatingIP != "" {
+		hostvars["do_floating_ip"] = floatingIP
+	}
+
+	candidates := map[string]string{
+		hostAddrPublicV4:   pubV4,
+		hostAddrPrivateV4:  privV4,
+		hostAddrPublicV6:   pubV6,
+		hostAddrFloatingIP: floatingIP,
+		hostAddrName:       d.Name,
+	}
+
+	// Try the requested policy first, then fall back through the rest in a
+	// fixed order so we always produce something usable.
+	fallbackOrder := []string{policy, hostAddrPublicV4, hostAddrPrivateV4, hostAddrPublicV6, hostAddrFloatingIP, hostAddrName}
+	for _, p := range fallbackOrder {
+		if addr, ok := candidates[p]; ok && addr != "" {
+			hostvars["ansible_host"] = addr
+			return
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/digitalocean/godo"
+)
+
+func TestSetHostAddressUsesRequestedPolicy(t *testing.T) {
+	d := godo.Droplet{
+		Name: "web-1",
+		Networks: &godo.Networks{
+			V4: []godo.NetworkV4{{IPAddress: "10.0.0.5", Type: "private"}, {IPAddress: "203.0.113.1", Type: "public"}},
+		},
+	}
+	hostvars := make(map[string]interface{})
+
+	setHostAddress(d, "", hostAddrPrivateV4, hostvars)
+
+	if hostvars["ansible_host"] != "10.0.0.5" {
+		t.Fatalf("expected ansible_host=10.0.0.5, got %v", hostvars["ansible_host"])
+	}
+}
+
+func TestSetHostAddressFallsBackWhenPolicyAddressMissing(t *testing.T) {
+	// Not-yet-provisioned droplet: nil Networks, no floating IP. The
+	// requested policy (private_v4) has nothing to offer, so we should fall
+	// through the fixed order and land on the droplet name.
+	d := godo.Droplet{Name: "new-droplet", Networks: nil}
+	hostvars := make(map[string]interface{})
+
+	setHostAddress(d, "", hostAddrPrivateV4, hostvars)
+
+	if hostvars["ansible_host"] != "new-droplet" {
+		t.Fatalf("expected fallback to droplet name, got %v", hostvars["ansible_host"])
+	}
+}
+
+func TestSetHostAddressFallsBackToFloatingIP(t *testing.T) {
+	d := godo.Droplet{Name: "web-1", Networks: nil}
+	hostvars := make(map[string]interface{})
+
+	setHostAddress(d, "198.51.100.9", hostAddrPublicV4, hostvars)
+
+	if hostvars["ansible_host"] != "198.51.100.9" {
+		t.Fatalf("expected fallback to floating IP, got %v", hostvars["ansible_host"])
+	}
+}
+
+func TestPublicV4PrivateV4PublicV6NilNetworks(t *testing.T) {
+	d := godo.Droplet{Networks: nil}
+
+	if got := publicV4(d); got != "" {
+		t.Errorf("publicV4() = %q, want empty", got)
+	}
+	if got := privateV4(d); got != "" {
+		t.Errorf("privateV4() = %q, want empty", got)
+	}
+	if got := publicV6(d); got != "" {
+		t.Errorf("publicV6() = %q, want empty", got)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/digitalocean/godo"
+)
+
+// Tags of this form set a hostvar on the droplet they're applied to, e.g.
+// "ansible_var__ansible_user--deploy" sets hostvars[host]["ansible_user"] = "deploy".
+// DO tag names only allow letters, numbers, ':', '-', and '_', so "=" can't be
+// used as the key/value separator here.
+const ansibleVarTagPrefix string = "ansible_var__"
+
+// The separator between key and value in an ansible_var__ tag.
+const ansibleVarSeparator string = "--"
+
+// Tags of this form add the droplet to a group named after whatever follows
+// the prefix, instead of (or in addition to) the raw tag itself.
+const ansibleGroupTagPrefix string = "ansible_group__"
+
+// True if t is one of our special ansible_var__/ansible_group__ tags rather
+// than a plain user tag that should become its own raw tag group.
+func isMetadataTag(t string) bool {
+	return strings.HasPrefix(t, ansibleVarTagPrefix) || strings.HasPrefix(t, ansibleGroupTagPrefix)
+}
+
+// Apply any ansible_var__/ansible_group__ tags on d to its hostvars and group
+// memberships.
+func applyTagMetadata(inv *ansibleInventory, d godo.Droplet, prefix string) {
+	hostvars, ok := inv.Meta.Hostvars[d.Name].(map[string]interface{})
+	if !ok {
+		hostvars = make(map[string]interface{})
+		inv.Meta.Hostvars[d.Name] = hostvars
+	}
+
+	for _, t := range d.Tags {
+		switch {
+		case strings.HasPrefix(t, ansibleVarTagPrefix):
+			rest := strings.TrimPrefix(t, ansibleVarTagPrefix)
+			kv := strings.SplitN(rest, ansibleVarSeparator, 2)
+			if len(kv) != 2 || kv[0] == "" {
+				fmt.Fprintf(os.Stderr, "warning: ignoring malformed tag %q on %s, expected %skey%svalue\n", t, d.Name, ansibleVarTagPrefix, ansibleVarSeparator)
+				continue
+			}
+			hostvars[kv[0]] = kv[1]
+
+		case strings.HasPrefix(t, ansibleGroupTagPrefix):
+			name := strings.TrimPrefix(t, ansibleGroupTagPrefix)
+			if name == "" {
+				continue
+			}
+			addToGroup(inv, prefix+name, d.Name)
+		}
+	}
+}
+
+// Fetch /v2/tags/{tag} for every tag we saw on a droplet, so that tags shared
+// with non-droplet resources (volumes, databases) surface as an Ansible group
+// whose children point at the droplet-tag group rather than being silently
+// dropped.
+func (c doClient) linkSharedTagGroups(ctx context.Context, inv *ansibleInventory, prefix string, tags []string) {
+	for _, t := range tags {
+		if isMetadataTag(t) {
+			continue
+		}
+
+		tag, _, err := c.gc.Tags.Get(ctx, t)
+		if err != nil || tag.Resources == nil {
+			continue
+		}
+
+		sharedWithOthers := (tag.Resources.Volumes != nil && tag.Resources.Volumes.Count > 0) ||
+			(tag.Resources.Databases != nil && tag.Resources.Databases.Count > 0)
+		if !sharedWithOthers {
+			continue
+		}
+
+		group := &ansibleGroup{
+			Children: []string{prefix + t},
+			Vars:     map[string]interface{}{},
+		}
+		if tag.Resources.Volumes != nil {
+			group.Vars["do_tag_volumes_count"] = tag.Resources.Volumes.Count
+		}
+		if tag.Resources.Databases != nil {
+			group.Vars["do_tag_databases_count"] = tag.Resources.Databases.Count
+		}
+		inv.Groups[prefix+"tag_"+t] = group
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/digitalocean/godo"
+)
+
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %s", err)
+	}
+	orig := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = orig }()
+
+	fn()
+
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to read captured stdout: %s", err)
+	}
+	return string(out)
+}
+
+func newInventoryForTest() *ansibleInventory {
+	inv := &ansibleInventory{Groups: make(map[string]*ansibleGroup)}
+	inv.Meta.Hostvars = make(map[string]interface{})
+	return inv
+}
+
+func TestApplyTagMetadataSetsVarOnWellFormedTag(t *testing.T) {
+	inv := newInventoryForTest()
+	d := godo.Droplet{Name: "web-1", Tags: []string{"ansible_var__ansible_user--deploy"}}
+
+	applyTagMetadata(inv, d, "do_")
+
+	hostvars := inv.Meta.Hostvars["web-1"].(map[string]interface{})
+	if hostvars["ansible_user"] != "deploy" {
+		t.Fatalf("expected ansible_user=deploy, got %v", hostvars["ansible_user"])
+	}
+}
+
+func TestApplyTagMetadataIgnoresMalformedTagWithoutTouchingStdout(t *testing.T) {
+	inv := newInventoryForTest()
+	d := godo.Droplet{Name: "web-1", Tags: []string{"ansible_var__badtag"}}
+
+	stdout := captureStdout(t, func() {
+		applyTagMetadata(inv, d, "do_")
+	})
+
+	if stdout != "" {
+		t.Fatalf("expected nothing written to stdout, got %q", stdout)
+	}
+
+	hostvars := inv.Meta.Hostvars["web-1"].(map[string]interface{})
+	if len(hostvars) != 0 {
+		t.Fatalf("expected malformed tag to set no hostvars, got %v", hostvars)
+	}
+}
+
+func TestApplyTagMetadataAddsGroupTag(t *testing.T) {
+	inv := newInventoryForTest()
+	d := godo.Droplet{Name: "web-1", Tags: []string{"ansible_group__webservers"}}
+
+	applyTagMetadata(inv, d, "do_")
+
+	group, ok := inv.Groups["do_webservers"]
+	if !ok {
+		t.Fatalf("expected group do_webservers to exist")
+	}
+	if len(group.Hosts) != 1 || group.Hosts[0] != "web-1" {
+		t.Fatalf("expected web-1 in do_webservers, got %v", group.Hosts)
+	}
+}
+
+func TestIsMetadataTag(t *testing.T) {
+	cases := map[string]bool{
+		"ansible_var__foo--bar": true,
+		"ansible_group__foo":    true,
+		"production":            false,
+	}
+	for tag, want := range cases {
+		if got := isMetadataTag(tag); got != want {
+			t.Errorf("isMetadataTag(%q) = %v, want %v", tag, got, want)
+		}
+	}
+}